@@ -1,17 +1,59 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	_ "net/http/pprof"
 
-	"github.com/urjitbhatia/yaad/cmd"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/prometheus"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+
+	"github.com/urjitbhatia/yaad/pkg/protocol"
+	"github.com/urjitbhatia/yaad/pkg/yaad"
 )
 
 func main() {
 	// logrus.SetLevel(logrus.DebugLevel)
+
+	// The otel-Prometheus bridge registers its collector on the default
+	// Prometheus registry, so promhttp.Handler() below actually has
+	// something to scrape instead of an empty registry.
+	exporter, err := prometheus.New()
+	if err != nil {
+		log.Fatalf("failed to build prometheus exporter: %v", err)
+	}
+	meterProvider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(exporter))
+	defer meterProvider.Shutdown(context.Background())
+
+	tracerProvider := sdktrace.NewTracerProvider()
+	defer tracerProvider.Shutdown(context.Background())
+	otel.SetTracerProvider(tracerProvider)
+
+	metrics, err := yaad.NewMetrics(meterProvider.Meter("github.com/urjitbhatia/yaad"))
+	if err != nil {
+		log.Fatalf("failed to build yaad metrics: %v", err)
+	}
+
+	opts := yaad.Options{
+		TracerProvider: tracerProvider,
+		Metrics:        metrics,
+	}
+
 	go func() {
+		// /debug/pprof/* comes from the blank net/http/pprof import above;
+		// /metrics is the Prometheus scrape endpoint for the OTel metrics
+		// Hub records.
+		http.Handle("/metrics", promhttp.Handler())
 		log.Println(http.ListenAndServe(":6060", nil))
 	}()
-	cmd.Execute()
+
+	srv := protocol.NewYaadServer(opts)
+	if err := srv.ListenAndServe(context.Background(), "tcp", ":11300"); err != nil {
+		logrus.WithError(err).Fatal("yaad server stopped")
+	}
 }
@@ -1,10 +1,12 @@
 package protocol
 
 import (
+	"context"
 	"net"
 	"net/textproto"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	metrics "github.com/classdojo/governor/metrics"
@@ -49,8 +51,16 @@ var ErrUnknownCmd errResponse = []byte(`UNKNOWN_COMMAND\r\n`)
 
 // Server is a yaad server
 type Server struct {
-	l net.Listener
+	*yaad.BaseService
+
+	l    net.Listener
+	opts yaad.Options
 	// srv BeanstalkdSrv
+
+	connMu sync.Mutex
+	conns  map[int]*Connection
+
+	tube *TubeYaad
 }
 
 // Connection implements a yaad + beanstalkd protocol server
@@ -66,9 +76,15 @@ type Connection struct {
 // 	return &Server{srv: NewSrvStub()}
 // }
 
-// NewYaadServer returns a pointer to a new yaad server
-func NewYaadServer() *Server {
+// NewYaadServer returns a pointer to a new yaad server. opts carries the
+// tracer/metrics threaded down into every tube's hub; it may be the zero
+// value, which traces onto the global otel TracerProvider and skips the
+// extra metrics.
+func NewYaadServer(opts yaad.Options) *Server {
 	return &Server{
+		BaseService: yaad.NewBaseService(),
+		opts:        opts,
+		conns:       make(map[int]*Connection),
 		// srv: NewSrvYaad()
 	}
 }
@@ -94,45 +110,123 @@ func (s *Server) Close() error {
 	return s.l.Close()
 }
 
-// ListenAndServe starts listening for new connections (blocking)
-func (s *Server) ListenAndServe(protocol, address string) error {
+// Stop closes the listener so Accept unblocks, then waits for in-flight
+// connections to drain, subject to ctx. Any connection still open when ctx
+// is done is force-closed. It then stops the default tube (and its hub's
+// background goroutines) so a graceful shutdown doesn't leak them.
+func (s *Server) Stop(ctx context.Context) error {
+	if s.l != nil {
+		if err := s.l.Close(); err != nil {
+			logrus.WithError(err).Warn("error closing listener during shutdown")
+		}
+	}
+
+	err := s.BaseService.Stop(ctx)
+	if err != nil {
+		// ctx ran out before connections drained on their own - force them closed.
+		s.connMu.Lock()
+		for _, c := range s.conns {
+			c.Close()
+		}
+		s.connMu.Unlock()
+	}
+
+	if s.tube != nil {
+		if tubeErr := s.tube.Stop(ctx); tubeErr != nil && err == nil {
+			err = tubeErr
+		}
+	}
+	return err
+}
+
+func (s *Server) trackConn(c *Connection) {
+	s.connMu.Lock()
+	s.conns[c.id] = c
+	s.connMu.Unlock()
+}
+
+func (s *Server) untrackConn(c *Connection) {
+	s.connMu.Lock()
+	delete(s.conns, c.id)
+	s.connMu.Unlock()
+}
+
+// connCtx returns a context derived from ctx that is also canceled once
+// Stop is called, so a connection's in-flight commands (a blocked
+// reserve-with-timeout, in particular) are given a chance to observe
+// shutdown instead of only ever unblocking on the client's own timeout or a
+// force-closed socket.
+func (s *Server) connCtx(ctx context.Context) context.Context {
+	cctx, cancel := context.WithCancel(ctx)
+	s.Go(func() {
+		select {
+		case <-s.Quit():
+			cancel()
+		case <-cctx.Done():
+		}
+	})
+	return cctx
+}
+
+// ListenAndServe starts listening for new connections (blocking) until ctx
+// is done or Stop is called.
+func (s *Server) ListenAndServe(ctx context.Context, protocol, address string) error {
 	metrics.SetupMetrics(true, "yaad")
 	stats = &protoMetrics{}
 	stats.putJob = metrics.NewCounter("putjob")
 	stats.deleteJob = metrics.NewCounter("deletejob")
 	stats.reserveJob = metrics.NewCounter("reservejob")
+	stats.releaseJob = metrics.NewCounter("releasejob")
+	stats.touchJob = metrics.NewCounter("touchjob")
 	stats.connections = metrics.NewCounter("connections")
 
 	if err := s.Listen(protocol, address); err != nil {
 		return err
 	}
+	if err := s.Start(ctx); err != nil {
+		return err
+	}
 
-	tube := &TubeYaad{
-		name:   "default",
-		paused: false,
-		hub:    yaad.NewHub(time.Second * 5),
+	tube := NewTubeYaad("default", time.Second*5, s.opts)
+	if err := tube.Start(ctx); err != nil {
+		return err
 	}
+	s.tube = tube
+
 	connectionID := 0
 	for {
 		// Wait for a connection.
 		conn, err := s.l.Accept()
 		if err != nil {
-			logrus.Fatal(err)
+			select {
+			case <-s.Quit():
+				// Listener was closed as part of a graceful shutdown.
+				logrus.Debug("accept loop stopping")
+				return nil
+			default:
+				return errors.Wrap(err, "accept failed")
+			}
 		}
 		go stats.connections.Incr(1)
 		connectionID++
-		// Handle the connection in a new goroutine.
-		// The loop then returns to accepting, so that
-		// multiple connections may be served concurrently.
-		go serve(&Connection{
+		c := &Connection{
 			Conn: textproto.NewConn(conn),
 			// srv:         s.srv,
 			defaultTube: tube,
-			id:          connectionID})
+			id:          connectionID}
+		s.trackConn(c)
+		// Handle the connection in a tracked goroutine so Stop can wait for
+		// it to drain. The loop then returns to accepting, so that
+		// multiple connections may be served concurrently.
+		connCtx := s.connCtx(ctx)
+		s.Go(func() {
+			defer s.untrackConn(c)
+			serve(connCtx, c)
+		})
 	}
 }
 
-func serve(conn *Connection) {
+func serve(ctx context.Context, conn *Connection) {
 	for {
 		line, err := conn.ReadLine()
 		if err != nil || line == "quit" {
@@ -168,17 +262,25 @@ func serve(conn *Connection) {
 			data := make([]byte, len(body))
 			copy(data, body)
 			body = nil
-			putCmd(conn, parts[1:], data[:])
+			putCmd(ctx, conn, parts[1:], data[:])
 		case reserve:
 			go stats.reserveJob.Incr(1)
-			reserveCmd(conn, "0")
+			reserveCmd(ctx, conn, "0")
 		case reserveWithTimeout:
 			go stats.reserveJob.Incr(1)
-			reserveCmd(conn, parts[1])
+			reserveCmd(ctx, conn, parts[1])
 		case deleteJob:
 			go stats.deleteJob.Incr(1)
 			logrus.Debugf("I am deleting job: %s cid: %d", parts[1:], conn.id)
-			deleteJobCmd(conn, parts[1:])
+			deleteJobCmd(ctx, conn, parts[1:])
+		case release:
+			go stats.releaseJob.Incr(1)
+			logrus.Debugf("releasing job: %s cid: %d", parts[1:], conn.id)
+			releaseCmd(ctx, conn, parts[1:])
+		case touch:
+			go stats.touchJob.Incr(1)
+			logrus.Debugf("touching job: %s cid: %d", parts[1:], conn.id)
+			touchCmd(ctx, conn, parts[1:])
 		default:
 			// Echo cmd by default
 			conn.Writer.PrintfLine("%s", line)
@@ -191,6 +293,8 @@ type protoMetrics struct {
 	putJob      metrics.Counter
 	deleteJob   metrics.Counter
 	reserveJob  metrics.Counter
+	releaseJob  metrics.Counter
+	touchJob    metrics.Counter
 }
 
 var stats *protoMetrics
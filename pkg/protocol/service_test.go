@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/goleak"
+
+	"github.com/urjitbhatia/yaad/pkg/yaad"
+)
+
+// This test lives in package protocol (rather than protocol_test) so it can
+// reach the default tube ListenAndServe wires up on Server, since put/reserve
+// aren't exposed outside the package.
+var _ = Describe("Server lifecycle", func() {
+	It("stops the default tube's hub with no goroutine leaks when the server shuts down", func() {
+		defer goleak.VerifyNone(GinkgoT())
+
+		s := NewYaadServer(yaad.Options{})
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- s.ListenAndServe(ctx, "tcp", "127.0.0.1:0") }()
+
+		Eventually(func() *TubeYaad { return s.tube }, time.Second).ShouldNot(BeNil())
+
+		id, err := s.tube.put(ctx, 0, 0, []byte("payload"), 60)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(id).NotTo(BeEmpty())
+		Expect(s.tube.reserve(ctx, "0")).NotTo(BeNil())
+
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+		defer stopCancel()
+		Expect(s.Stop(stopCtx)).To(Succeed())
+
+		Eventually(serveErr, time.Second).Should(Receive(BeNil()))
+	})
+
+	It("unblocks an in-flight reserve-with-timeout once Stop is called, instead of only on the client's own deadline", func() {
+		defer goleak.VerifyNone(GinkgoT())
+
+		s := NewYaadServer(yaad.Options{})
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		serveErr := make(chan error, 1)
+		go func() { serveErr <- s.ListenAndServe(ctx, "tcp", "127.0.0.1:0") }()
+
+		Eventually(func() *TubeYaad { return s.tube }, time.Second).ShouldNot(BeNil())
+
+		// Mirrors what ListenAndServe derives per connection: a ctx that is
+		// canceled on Stop, not just on the client's own deadline.
+		connCtx := s.connCtx(ctx)
+
+		reserved := make(chan *Job, 1)
+		go func() { reserved <- s.tube.reserve(connCtx, "30") }()
+
+		stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+		defer stopCancel()
+		start := time.Now()
+		Expect(s.Stop(stopCtx)).To(Succeed())
+
+		Eventually(reserved, time.Second).Should(Receive(BeNil()))
+		Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+		Eventually(serveErr, time.Second).Should(Receive(BeNil()))
+	})
+})
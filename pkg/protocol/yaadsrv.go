@@ -1,10 +1,13 @@
 package protocol
 
 import (
+	"context"
 	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 
 	"github.com/urjitbhatia/yaad/pkg/yaad"
 )
@@ -16,21 +19,52 @@ type SrvYaad struct {
 
 // TubeYaad implements a yaad hub as a beanstalkd tube
 type TubeYaad struct {
+	*yaad.BaseService
+
 	name     string
 	paused   bool
 	jobIDCtr int
 	// Backed by a yaad hub
 	hub *yaad.Hub
+
+	opts yaad.Options
 }
 
-// NewSrvYaad returns a yaad BeanstalkdSrv
-func NewSrvYaad() BeanstalkdSrv {
-	y := SrvYaad{make(map[string]Tube)}
-	t := &TubeYaad{
-		name:   "default",
-		paused: false,
-		hub:    yaad.NewHub(time.Second * 5),
+// NewTubeYaad returns a TubeYaad backed by a fresh yaad.Hub with the given
+// spoke span. opts carries the tracer/metrics the tube's hub records onto;
+// it may be the zero value. The tube's background loops (and its hub's)
+// only start once Start is called.
+func NewTubeYaad(name string, spokeSpan time.Duration, opts yaad.Options) *TubeYaad {
+	return &TubeYaad{
+		BaseService: yaad.NewBaseService(),
+		name:        name,
+		paused:      false,
+		hub:         yaad.NewHub(spokeSpan, opts),
+		opts:        opts,
+	}
+}
+
+// Start brings up this tube's hub.
+func (t *TubeYaad) Start(ctx context.Context) error {
+	if err := t.BaseService.Start(ctx); err != nil {
+		return err
+	}
+	return t.hub.Start(ctx)
+}
+
+// Stop drains and stops this tube's hub, subject to ctx.
+func (t *TubeYaad) Stop(ctx context.Context) error {
+	if err := t.hub.Stop(ctx); err != nil {
+		return err
 	}
+	return t.BaseService.Stop(ctx)
+}
+
+// NewSrvYaad returns a yaad BeanstalkdSrv. opts carries the tracer/metrics
+// threaded down into the default tube's hub; it may be the zero value.
+func NewSrvYaad(opts yaad.Options) BeanstalkdSrv {
+	y := SrvYaad{make(map[string]Tube)}
+	t := NewTubeYaad("default", time.Second*5, opts)
 	y.tubes[t.name] = t
 	return &y
 }
@@ -58,57 +92,120 @@ func (t *TubeYaad) pauseTube(delay time.Duration) error {
 	return nil
 }
 
-func (t *TubeYaad) put(delay int, pri int32, body []byte, ttr int) (string, error) {
+func (t *TubeYaad) put(ctx context.Context, delay int, pri int32, body []byte, ttr int) (string, error) {
+	ctx, span := t.opts.Tracer().Start(ctx, "put")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("tube", t.name),
+		attribute.Int("delay", delay),
+	)
+
 	j := yaad.NewJobAutoID(time.Now().Add(time.Second*time.Duration(delay)), body)
 	j.SetOpts(pri, time.Duration(ttr)*time.Second)
 
-	err := t.hub.AddJob(j)
+	err := t.hub.AddJob(ctx, j)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
 		return "", err
 	}
 	t.jobIDCtr++
+	span.SetAttributes(attribute.String("job.id", j.ID()))
 	return j.ID(), nil
 }
 
-func (t *TubeYaad) reserve(timeoutSec string) *Job {
+func toProtoJob(j *yaad.Job) *Job {
+	return &Job{
+		body: j.Body(),
+		id:   j.ID(),
+		size: len(j.Body()),
+	}
+}
+
+func (t *TubeYaad) reserve(ctx context.Context, timeoutSec string) *Job {
+	ctx, span := t.opts.Tracer().Start(ctx, "reserve")
+	defer span.End()
+	span.SetAttributes(attribute.String("tube", t.name))
+
 	ts, err := strconv.Atoi(timeoutSec)
 	if err != nil {
 		logrus.Errorf("Error parsing timeout: %s", err)
+		span.SetStatus(codes.Error, err.Error())
 		return nil
 	}
 
 	logrus.Debug("yaad srv reserve")
 	// try once
-	if j := t.hub.Next(); j != nil {
-		return &Job{
-			body: j.Body(),
-			id:   j.ID(),
-			size: len(j.Body()),
-		}
+	if j := t.hub.Next(ctx); j != nil {
+		span.SetAttributes(attribute.String("job.id", j.ID()))
+		return toProtoJob(j)
 	}
 	if ts == 0 {
 		return nil
 	}
 
-	waitTill := time.Now().Add(time.Duration(ts) * time.Second)
-	// wait for timeout and keep trying
+	waitCtx, cancel := context.WithTimeout(ctx, time.Duration(ts)*time.Second)
+	defer cancel()
+
 	logrus.Debug("waiting for reserve: ", timeoutSec)
-	for waitTill.After(time.Now()) {
-		if j := t.hub.Next(); j != nil {
-			return &Job{
-				body: j.Body(),
-				id:   j.ID(),
-				size: len(j.Body()),
-			}
+	for {
+		// Register interest before the final Next() check so a wakeup
+		// signaled in between isn't lost.
+		ready := t.hub.WaitReady()
+		if j := t.hub.Next(ctx); j != nil {
+			span.SetAttributes(attribute.String("job.id", j.ID()))
+			return toProtoJob(j)
+		}
+		select {
+		case <-ready:
+			// A job may have become available - or another waiter may have
+			// already taken it. Either way, loop and re-check via Next().
+			continue
+		case <-waitCtx.Done():
+			logrus.Debug("yaad srv reserve done - no job found")
+			return nil
 		}
-		time.Sleep(time.Millisecond * 200)
 	}
-	logrus.Debug("yaad srv reserve done - no job found")
-	return nil
 }
 
-// Todo: handle cancelations for reserved jobs
-func (t *TubeYaad) deleteJob(id int) error {
+func (t *TubeYaad) deleteJob(ctx context.Context, id int) error {
+	ctx, span := t.opts.Tracer().Start(ctx, "deleteJob")
+	defer span.End()
+
+	strID := strconv.Itoa(id)
+	span.SetAttributes(attribute.String("job.id", strID))
+	err := t.hub.CancelJob(ctx, strID)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// release puts a reserved job back into the ready queue with a new priority
+// and delay, mirroring beanstalkd's release semantics.
+func (t *TubeYaad) release(ctx context.Context, id int, pri int32, delay int) error {
+	_, span := t.opts.Tracer().Start(ctx, "release")
+	defer span.End()
+
 	strID := strconv.Itoa(id)
-	return t.hub.CancelJob(strID)
+	span.SetAttributes(attribute.String("job.id", strID))
+	err := t.hub.ReleaseJob(strID, pri, time.Duration(delay)*time.Second)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
+
+// touch extends a reserved job's TTR deadline, mirroring beanstalkd's touch
+// semantics.
+func (t *TubeYaad) touch(ctx context.Context, id int) error {
+	_, span := t.opts.Tracer().Start(ctx, "touch")
+	defer span.End()
+
+	strID := strconv.Itoa(id)
+	span.SetAttributes(attribute.String("job.id", strID))
+	err := t.hub.TouchJob(strID)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
 }
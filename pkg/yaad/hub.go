@@ -1,310 +1,437 @@
 package yaad
 
 import (
-	"container/heap"
+	"context"
 	"errors"
+	"hash/fnv"
+	"runtime"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 const (
 	hundredYears = time.Hour * 24 * 365 * 100
 )
 
-// Hub is a time ordered collection of spokes
+// ErrHubStopping is returned by AddJob once the hub has been asked to stop -
+// the hub keeps serving Next()/CancelJob() for in-flight/reserved jobs so
+// they can drain, but refuses to take on new work.
+var ErrHubStopping = errors.New("hub: stopping, not accepting new jobs")
+
+// Hub is a time ordered collection of spokes, partitioned across a fixed
+// number of shards so that puts, reserves and TTR bookkeeping don't all
+// contend on a single mutex. Each job is routed to exactly one shard (by its
+// job ID if it is already due when added, by its spoke bound otherwise) and
+// stays owned by that shard once reserved, so
+// Next, CancelJob, ReleaseJob and TouchJob only ever need to fan out reads
+// across shards rather than coordinate locks between them.
 type Hub struct {
-	spokeSpan time.Duration
-	spokeMap  map[spokeBound]*Spoke // quick lookup map
-	spokes    *PriorityQueue
+	*BaseService
 
-	pastSpoke    *Spoke // Permanently pinned to the past
-	currentSpoke *Spoke // The current spoke
+	spokeSpan time.Duration
+	shards    []*shard
 
-	reservedJobs map[string]*Job // This could also be a spoke that order by TTL - optimize later
+	readyMu sync.Mutex
+	readyCh chan struct{}
 
-	removedJobsCount uint64
-	lock             *sync.Mutex
+	opts Options
 }
 
-// NewHub creates a new hub where adjacent spokes lie at the given
-// spokeSpan duration boundary.
-func NewHub(spokeSpan time.Duration) *Hub {
-	h := &Hub{
-		spokeSpan:        spokeSpan,
-		spokeMap:         make(map[spokeBound]*Spoke),
-		spokes:           &PriorityQueue{},
-		pastSpoke:        NewSpoke(time.Now().Add(-1*hundredYears), time.Now().Add(hundredYears)),
-		currentSpoke:     nil,
-		reservedJobs:     make(map[string]*Job),
-		removedJobsCount: 0,
-		lock:             &sync.Mutex{},
+// NewHub creates a new hub where adjacent spokes lie at the given spokeSpan
+// duration boundary, sharded opts.Shards ways (runtime.GOMAXPROCS(0) if
+// opts.Shards <= 0). The hub's background loops (status printer, etc) only
+// start once Start is called. opts may be the zero value, in which case Hub
+// traces onto the global otel TracerProvider and skips the extra metrics it
+// otherwise records alongside Status.
+func NewHub(spokeSpan time.Duration, opts Options) *Hub {
+	n := opts.Shards
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = newShard(spokeSpan)
 	}
-	heap.Init(h.spokes)
 
-	logrus.WithFields(logrus.Fields{
-		"start": h.pastSpoke.start.String(),
-		"end":   h.pastSpoke.end.String(),
-	}).Debug("Created hub with past spoke")
+	h := &Hub{
+		BaseService: NewBaseService(),
+		spokeSpan:   spokeSpan,
+		shards:      shards,
+		readyCh:     make(chan struct{}),
+		opts:        opts,
+	}
 
-	go h.StatusPrinter()
+	logrus.WithField("shards", n).Debug("Created hub")
 
 	return h
 }
 
+// Start brings up the hub's background loops. It must be called before the
+// hub is considered live.
+func (h *Hub) Start(ctx context.Context) error {
+	if err := h.BaseService.Start(ctx); err != nil {
+		return err
+	}
+	h.Go(h.statusPrinter)
+	h.Go(h.readyRoller)
+	h.Go(h.ttrReaper)
+	return nil
+}
+
+// Stop asks the hub to stop accepting new jobs and waits for its background
+// loops to exit, subject to ctx.
+func (h *Hub) Stop(ctx context.Context) error {
+	return h.BaseService.Stop(ctx)
+}
+
+// shardForJobID returns the shard whose past spoke takes jobID when the job
+// is added already due. Reservations are not routed through it: a reserved
+// job stays in the shard that handed it out, and lookups by job ID scan
+// every shard.
+func (h *Hub) shardForJobID(jobID string) *shard {
+	return h.shards[hashString(jobID)%uint32(len(h.shards))]
+}
+
+// shardForBound returns the shard owning spokes at bound b.
+func (h *Hub) shardForBound(b spokeBound) *shard {
+	return h.shards[hashString(b.start.String()+"|"+b.end.String())%uint32(len(h.shards))]
+}
+
+func hashString(s string) uint32 {
+	f := fnv.New32a()
+	f.Write([]byte(s))
+	return f.Sum32()
+}
+
 // PendingJobsCount return the number of jobs currently pending
 func (h *Hub) PendingJobsCount() int {
-	count := h.pastSpoke.PendingJobsLen()
-	for _, v := range h.spokeMap {
-		count += v.PendingJobsLen()
+	count := 0
+	for _, sh := range h.shards {
+		count += sh.pendingJobsCount()
 	}
-
 	return count
 }
 
 // CancelJob cancels a job if found. Calls are noop for unknown jobs
-func (h *Hub) CancelJob(jobID string) error {
-	h.lock.Lock()
-	defer h.lock.Unlock()
+func (h *Hub) CancelJob(ctx context.Context, jobID string) error {
+	_, span := h.opts.Tracer().Start(ctx, "Hub.CancelJob")
+	span.SetAttributes(attribute.String("job.id", jobID))
+	defer span.End()
 
 	logrus.Debug("cancel: ", jobID)
-	// Search if this job is reserved
-	if _, ok := h.reservedJobs[jobID]; ok {
-		logrus.Debug("found in reserved: ", jobID)
-		delete(h.reservedJobs, jobID)
-		h.removedJobsCount++
+	for _, sh := range h.shards {
+		outcome, err := sh.cancelJob(jobID)
+		if err == errShardMiss {
+			continue
+		}
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return err
+		}
+		logrus.Debug("cancel found: ", jobID)
+		span.SetAttributes(attribute.String("outcome", outcome))
 		return nil
 	}
 
-	s, err := h.FindOwnerSpoke(jobID)
-	if err != nil {
-		logrus.Debug("cancel found no owner spoke: ", jobID)
-		return err
-	}
-	logrus.Debug("cancel found owner spoke: ", jobID)
-	s.CancelJob(jobID)
-	h.removedJobsCount++
-	return nil
+	logrus.Debug("cancel found no owner spoke: ", jobID)
+	err := errors.New("Cannot find job owner spoke")
+	span.SetAttributes(attribute.String("outcome", "miss"))
+	span.SetStatus(codes.Error, err.Error())
+	return err
 }
 
 // FindOwnerSpoke returns the spoke that owns this job
 func (h *Hub) FindOwnerSpoke(jobID string) (*Spoke, error) {
+	for _, sh := range h.shards {
+		if sp, err := sh.findOwnerSpoke(jobID); err == nil {
+			return sp, nil
+		}
+	}
+	return nil, errors.New("Cannot find job owner spoke")
+}
 
-	if h.pastSpoke.OwnsJob(jobID) {
-		return h.pastSpoke, nil
+// Next runs a small tournament across every shard and returns the job with
+// the earliest trigger time that's ready now, or nil if nothing is ready.
+// Spoke doesn't expose its head job's trigger time without popping, so this
+// can't peek-and-compare non-destructively: every shard with something ready
+// is popped (which also reserves the job), the earliest of those wins, and
+// every runner-up is re-added via AddJob, which routes it straight back into
+// the pending pool since it's still due. That costs more shard contention
+// per reserve than the old round-robin-first-hit approach, but round-robin
+// could hand out a later-triggered job from the first-scanned shard ahead of
+// an earlier one sitting in a shard scanned later in the same pass - this
+// doesn't.
+func (h *Hub) Next(ctx context.Context) *Job {
+	_, span := h.opts.Tracer().Start(ctx, "Hub.Next")
+	defer span.End()
+	outcome := "miss"
+	defer func() { span.SetAttributes(attribute.String("outcome", outcome)) }()
+
+	type candidate struct {
+		j            *Job
+		shardOutcome string
+		sh           *shard
+	}
+	var candidates []candidate
+	for _, sh := range h.shards {
+		if j, shardOutcome := sh.next(); j != nil {
+			candidates = append(candidates, candidate{j, shardOutcome, sh})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
 	}
 
-	// Checking the current spoke - lock the hub
-	if h.currentSpoke != nil && h.currentSpoke.OwnsJob(jobID) {
-		return h.currentSpoke, nil
+	winner := 0
+	for i := 1; i < len(candidates); i++ {
+		if candidates[i].j.TriggerAt().Before(candidates[winner].j.TriggerAt()) {
+			winner = i
+		}
 	}
 
-	for _, v := range h.spokeMap {
-		if v.OwnsJob(jobID) {
-			return v, nil
+	for i, c := range candidates {
+		if i == winner {
+			continue
+		}
+		// sh.next reserved the runner-up; drop that reservation before it
+		// goes back into the pending pool, and keep it if the re-add fails.
+		c.sh.releaseJob(c.j.id)
+		if err := h.AddJob(ctx, c.j); err != nil {
+			c.sh.restoreReservation(c.j)
+			logrus.WithError(err).WithField("JobID", c.j.id).Error("failed to re-queue tournament runner-up")
 		}
 	}
-	return nil, errors.New("Cannot find job owner spoke")
-}
 
-// addSpoke adds spoke s to this hub
-func (h *Hub) addSpoke(s *Spoke) {
-	h.spokeMap[s.spokeBound] = s
-	heap.Push(h.spokes, s.AsPriorityItem())
+	j := candidates[winner].j
+	h.recordJobLatency(j)
+	span.SetAttributes(attribute.String("job.id", j.ID()))
+	outcome = candidates[winner].shardOutcome
+	return j
 }
 
-// Next returns the next job that is ready now or returns nil.
-func (h *Hub) Next() *Job {
-	h.lock.Lock()
-	defer h.lock.Unlock()
-
-	pastLocker := h.pastSpoke.GetLocker()
-	pastLocker.Lock()
-	defer pastLocker.Unlock()
-
-	// Find a job in past spoke
-	j := h.pastSpoke.Next()
-	if j != nil {
-		h.reserve(j)
-		logrus.Debug("Got job from past spoke")
-		return j
-	}
-	// Checked past spoke
-
-	// Fix the heap
-	heap.Init(h.spokes)
-
-	// Find a job in current spoke
-	// If current is empty and now expired, prune it...
-	if h.currentSpoke != nil {
-		if h.currentSpoke.PendingJobsLen() == 0 && h.currentSpoke.AsTemporalState() == Past {
-			logrus.Debug("pruning the current spoke")
-			// This routine could be unfortunate - it found a currentspoke that was expired
-			// so it has the pay the price finding the next candidate
-			delete(h.spokeMap, h.currentSpoke.spokeBound)
-			h.currentSpoke = nil
+// ReleaseJob releases jobID from its reservation and re-queues it with a new
+// priority and delay, mirroring beanstalkd's release semantics. It is a
+// no-op error if jobID isn't currently reserved. If the re-AddJob fails (for
+// example, ErrHubStopping while the hub is draining), the job's reservation
+// is restored rather than being dropped.
+func (h *Hub) ReleaseJob(jobID string, pri int32, delay time.Duration) error {
+	for _, sh := range h.shards {
+		j, ok := sh.releaseJob(jobID)
+		if !ok {
+			continue
+		}
+		j.SetOpts(pri, j.ttr)
+		j.triggerAt = time.Now().Add(delay)
+		if err := h.AddJob(context.Background(), j); err != nil {
+			sh.restoreReservation(j)
+			return err
 		}
+		return nil
 	}
+	return errors.New("hub: job is not reserved")
+}
 
-	// No currently assigned spoke
-	if h.currentSpoke == nil {
-		if h.spokes.Len() == 0 {
-			logrus.Debug("No spokes")
-			// No spokes - can't do anything. Return
+// TouchJob resets jobID's TTR deadline to now plus the job's ttr, mirroring
+// beanstalkd's touch semantics. It is a no-op error if jobID isn't
+// currently reserved.
+func (h *Hub) TouchJob(jobID string) error {
+	for _, sh := range h.shards {
+		if sh.touchJob(jobID) {
 			return nil
 		}
+	}
+	return errors.New("hub: job is not reserved")
+}
 
-		// New current candidate
-		item := h.spokes.AtIdx(0)
-		current := item.value.(*Spoke)
-		switch current.AsTemporalState() {
-		case Future:
-			// Next in time is still not current. Can't do anything. Return
-			return nil
-		case Past, Current:
-			// We have found a new current spoke
-			h.currentSpoke = current
-			// Pop it from the queue - this is now a current spoke
-			heap.Pop(h.spokes)
+// ttrReaper periodically releases reservations whose TTR deadline has
+// passed back into circulation so another consumer can pick them up.
+func (h *Hub) ttrReaper() {
+	t := time.NewTicker(h.spokeSpan)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			h.releaseExpiredReservations()
+		case <-h.Quit():
+			logrus.Debug("hub ttr reaper stopping")
+			return
 		}
 	}
+}
 
-	// Read from current spoke
-
-	// Assert - At this point, hub should have a current spoke
-	if h.currentSpoke == nil {
-		logrus.Panic("Unreachable state :: hub has a nil spoke after candidate search")
+// releaseExpiredReservations sweeps every shard for TTR-expired reservations
+// and re-adds the underlying jobs with TriggerAt = now. A job whose re-AddJob
+// fails (for example, ErrHubStopping while the hub is draining) has its
+// reservation restored instead of being dropped - the next reaper tick will
+// retry it.
+func (h *Hub) releaseExpiredReservations() {
+	now := time.Now()
+	for _, sh := range h.shards {
+		for _, j := range sh.popExpiredReservations(now) {
+			j.triggerAt = now
+			if err := h.AddJob(context.Background(), j); err != nil {
+				logrus.WithError(err).WithField("JobID", j.id).Error("failed to re-queue TTR-expired job, restoring reservation")
+				sh.restoreReservation(j)
+				continue
+			}
+			if h.opts.Metrics != nil {
+				h.opts.Metrics.TTRExpired.Add(context.Background(), 1)
+			}
+			logrus.WithField("JobID", j.id).Info("re-queued TTR-expired reservation")
+		}
 	}
+}
 
-	currentLocker := h.currentSpoke.GetLocker()
-	currentLocker.Lock()
-	defer currentLocker.Unlock()
-
-	j = h.currentSpoke.Next()
-	if j == nil {
-		// no job - return
-		logrus.Debug("No job in current spoke")
-		return nil
+// recordJobLatency records how long j sat ready before being reserved.
+func (h *Hub) recordJobLatency(j *Job) {
+	if h.opts.Metrics == nil {
+		return
 	}
+	h.opts.Metrics.JobLatency.Record(context.Background(), time.Since(j.TriggerAt()).Seconds())
+}
 
-	logrus.Debug("reserving job: ", j.id)
-	h.reserve(j)
-
-	return j
+// WaitReady returns a channel that is closed the next time the hub may have
+// a job ready to hand out - either AddJob inserted one that is already due,
+// or the background roller rolled a spoke into the present. The signal is a
+// hint, not a guarantee: callers must register interest by calling WaitReady
+// *before* their final Next() check (so a signal delivered in between isn't
+// missed), and must re-check Next() after every wakeup since another waiter
+// may have already claimed the job. WaitReady itself carries no deadline -
+// callers that need to give up after a timeout select on the returned
+// channel alongside their own ctx.Done(), as reserve does.
+func (h *Hub) WaitReady() <-chan struct{} {
+	h.readyMu.Lock()
+	defer h.readyMu.Unlock()
+	return h.readyCh
 }
 
-func (h *Hub) reserve(j *Job) {
-	h.reservedJobs[j.id] = j
+// signalReady wakes up everyone currently waiting on WaitReady.
+func (h *Hub) signalReady() {
+	h.readyMu.Lock()
+	defer h.readyMu.Unlock()
+	close(h.readyCh)
+	h.readyCh = make(chan struct{})
 }
 
-func (h *Hub) mergeQueues(pq *PriorityQueue) {
-	for pq.Len() > 0 {
-		i := heap.Pop(pq)
-		h.spokes.Push(i)
+// readyRoller periodically signals WaitReady waiters so that a job whose
+// spoke rolls from future to current is picked up promptly even if nothing
+// else nudges the hub.
+func (h *Hub) readyRoller() {
+	t := time.NewTicker(h.spokeSpan)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			h.signalReady()
+		case <-h.Quit():
+			logrus.Debug("hub ready roller stopping")
+			return
+		}
 	}
 }
 
-// Prune clears spokes which are expired and have no jobs
-// returns the number of spokes pruned
+// Prune clears spokes which are expired and have no jobs across every shard,
+// returning the number of spokes examined.
 func (h *Hub) Prune() int {
 	pruned := 0
-	for k, v := range h.spokeMap {
-		if v.IsExpired() && v.PendingJobsLen() == 0 {
-			delete(h.spokeMap, k)
-		}
-		pruned++
+	for _, sh := range h.shards {
+		pruned += sh.prune()
 	}
-
 	return pruned
 }
 
 // AddJob to this hub. Hub should never reject a job - this method will panic if that happens
-func (h *Hub) AddJob(j *Job) error {
+func (h *Hub) AddJob(ctx context.Context, j *Job) error {
+	_, span := h.opts.Tracer().Start(ctx, "Hub.AddJob")
+	span.SetAttributes(
+		attribute.String("job.id", j.ID()),
+		attribute.String("job.delay", time.Until(j.TriggerAt()).String()),
+	)
+	defer span.End()
+
+	if h.Stopping() {
+		span.SetAttributes(attribute.String("outcome", "miss"))
+		span.SetStatus(codes.Error, ErrHubStopping.Error())
+		return ErrHubStopping
+	}
 
 	switch j.AsTemporalState() {
 	case Past:
-		pastLocker := h.pastSpoke.GetLocker()
-		pastLocker.Lock()
-		defer pastLocker.Unlock()
-
+		sh := h.shardForJobID(j.id)
 		logrus.WithField("JobID", j.ID).Debug("Adding job to past spoke")
-		err := h.pastSpoke.AddJob(j)
-		if err != nil {
+		if err := sh.addPastJob(j); err != nil {
 			logrus.WithError(err).Error("Past spoke rejected job. This should never happen")
+			span.SetStatus(codes.Error, err.Error())
 			return err
 		}
+		span.SetAttributes(attribute.String("outcome", "hit_past_spoke"))
+		// Already due - wake up anyone long-polling in reserve.
+		h.signalReady()
 	case Future:
-		// Lock hub so that current spoke isn't replaced
-		h.lock.Lock()
-		defer h.lock.Unlock()
-
-		// Lock current spoke so that add fixes the PQ as it adds
-		if h.currentSpoke != nil {
-			currLocker := h.currentSpoke.GetLocker()
-			currLocker.Lock()
-			defer currLocker.Unlock()
-
-			if h.currentSpoke.ContainsJob(j) {
-				err := h.currentSpoke.AddJob(j)
-				if err != nil {
-					logrus.WithError(err).Error("Current spoke rejected job. This should never happen")
-					return err
-				}
-				return nil
-			}
-		}
-
-		// Search for a spoke that can take ownership of this job
-		// Reads are still going to be ordered anyways
 		jobBound := j.AsBound(h.spokeSpan)
-		candidate, ok := h.spokeMap[jobBound]
-		if ok {
-			// Found a candidate that can take this job
-			err := candidate.AddJob(j)
-			if err != nil {
-				logrus.WithError(err).Error("Hub should always accept a job. No spoke accepted")
-				return err
-			}
-			// Accepted, all done...
-			return nil
-		}
+		span.SetAttributes(
+			attribute.String("spoke.bound.start", jobBound.start.String()),
+			attribute.String("spoke.bound.end", jobBound.end.String()),
+		)
 
-		// Time to create a new spoke for this job
-		s := NewSpoke(jobBound.start, jobBound.end)
-		err := s.AddJob(j)
+		sh := h.shardForBound(jobBound)
+		outcome, err := sh.addFutureJob(j, jobBound)
 		if err != nil {
 			logrus.WithError(err).Error("Hub should always accept a job. No spoke accepted")
+			span.SetStatus(codes.Error, err.Error())
 			return err
 		}
-
-		// h is still locked here so it's ok
-		h.addSpoke(s)
+		span.SetAttributes(attribute.String("outcome", outcome))
 	}
 	return nil
 }
 
-// Status prints the state of the spokes of this hub
+// Status prints the state of the spokes of this hub and, if Metrics are
+// configured, samples the spoke count / pending / reserved gauges.
 func (h *Hub) Status() {
+	var spokeCount, pending, reserved int
+	var removed uint64
+	for _, sh := range h.shards {
+		st := sh.status()
+		spokeCount += st.spokes
+		pending += st.pending
+		reserved += st.reserved
+		removed += st.removed
+	}
+
+	if h.opts.Metrics != nil {
+		h.opts.Metrics.SpokeCount.Record(context.Background(), int64(spokeCount))
+		h.opts.Metrics.observeCounts(pending, reserved)
+	}
+
 	logrus.Info("-------------------------------------------------------------")
-	logrus.Infof("Hub has %d spokes", len(h.spokeMap))
-	logrus.Infof("Hub has %d total jobs", h.PendingJobsCount())
-	h.lock.Lock()
-	logrus.Infof("Hub has %d reserved jobs", len(h.reservedJobs))
-	h.lock.Unlock()
-	logrus.Infof("Hub has %d removed jobs", h.removedJobsCount)
-	logrus.Infof("Past spoke has %d jobs", h.pastSpoke.PendingJobsLen())
-	for _, s := range h.spokeMap {
-		logrus.Infof("Spoke %s has %d jobs", s.id, s.PendingJobsLen())
-		logrus.Debugf("Spoke %s start: %s end %s", s.id, s.start.String(), s.end.String())
+	logrus.Infof("Hub has %d shards", len(h.shards))
+	logrus.Infof("Hub has %d spokes", spokeCount)
+	logrus.Infof("Hub has %d total jobs", pending)
+	logrus.Infof("Hub has %d reserved jobs", reserved)
+	logrus.Infof("Hub has %d removed jobs", removed)
+	for i, sh := range h.shards {
+		sh.logStatus(i)
 	}
 	logrus.Info("-------------------------------------------------------------")
 }
 
-// StatusPrinter starts a status printer that prints hub stats over some time interval
-func (h *Hub) StatusPrinter() {
+// statusPrinter periodically prints hub stats until the hub is stopped.
+func (h *Hub) statusPrinter() {
 	t := time.NewTicker(time.Second * 10)
-	for range t.C {
-		h.Status()
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			h.Status()
+		case <-h.Quit():
+			logrus.Debug("hub status printer stopping")
+			return
+		}
 	}
 }
@@ -0,0 +1,52 @@
+package yaad_test
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/urjitbhatia/yaad/pkg/yaad"
+)
+
+// BenchmarkHubPutReserve puts 1M jobs across many goroutines and reserves
+// them all back out, comparing a single shard (equivalent to the old
+// single-mutex Hub) against the default GOMAXPROCS-sharded configuration.
+func BenchmarkHubPutReserve(b *testing.B) {
+	const jobCount = 1_000_000
+	workers := runtime.GOMAXPROCS(0)
+	perWorker := jobCount / workers
+
+	for _, shards := range []int{1, runtime.GOMAXPROCS(0)} {
+		shards := shards
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h := NewHub(time.Second, Options{Shards: shards})
+
+				var wg sync.WaitGroup
+				for w := 0; w < workers; w++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						for n := 0; n < perWorker; n++ {
+							j := NewJobAutoID(time.Now(), nil)
+							if err := h.AddJob(context.Background(), j); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					}()
+				}
+				wg.Wait()
+
+				for reserved := 0; reserved < perWorker*workers; reserved++ {
+					if h.Next(context.Background()) == nil {
+						b.Fatal("expected a job, got none")
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package yaad_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/urjitbhatia/yaad/pkg/yaad"
+)
+
+var _ = Describe("Sharded hub", func() {
+	It("finds, reserves and cancels jobs correctly across many shards", func() {
+		h := NewHub(time.Second, Options{Shards: 8})
+
+		future := make([]*Job, 0, 32)
+		for i := 0; i < 32; i++ {
+			j := NewJobAutoID(time.Now().Add(time.Minute), []byte("payload"))
+			Expect(h.AddJob(context.Background(), j)).To(Succeed())
+			future = append(future, j)
+		}
+
+		for _, j := range future {
+			sp, err := h.FindOwnerSpoke(j.ID())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(sp.OwnsJob(j.ID())).To(BeTrue())
+		}
+
+		Expect(h.CancelJob(context.Background(), future[0].ID())).To(Succeed())
+		_, err := h.FindOwnerSpoke(future[0].ID())
+		Expect(err).To(HaveOccurred())
+
+		Expect(h.PendingJobsCount()).To(Equal(len(future) - 1))
+	})
+
+	It("reserves due jobs from whichever shard owns them", func() {
+		h := NewHub(time.Second, Options{Shards: 8})
+
+		ids := map[string]bool{}
+		for i := 0; i < 16; i++ {
+			j := NewJobAutoID(time.Now(), []byte("payload"))
+			Expect(h.AddJob(context.Background(), j)).To(Succeed())
+			ids[j.ID()] = true
+		}
+
+		for i := 0; i < 16; i++ {
+			j := h.Next(context.Background())
+			Expect(j).NotTo(BeNil())
+			Expect(ids).To(HaveKey(j.ID()))
+			delete(ids, j.ID())
+		}
+		Expect(h.Next(context.Background())).To(BeNil())
+	})
+})
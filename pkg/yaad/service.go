@@ -0,0 +1,103 @@
+package yaad
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrAlreadyStarted is returned by Start if the service has already been started.
+var ErrAlreadyStarted = errors.New("service: already started")
+
+// BaseService is a small start/stop/wait lifecycle that long running
+// components (Hub, Server, TubeYaad) can embed instead of hand rolling their
+// own done channels. It is deliberately minimal - akin to Tendermint's
+// service.BaseService - and only tracks whether it has been started/stopped
+// and which goroutines still need to drain before Stop can return.
+type BaseService struct {
+	quit chan struct{}
+	wg   sync.WaitGroup
+
+	mu      sync.Mutex
+	started bool
+	stopped bool
+}
+
+// NewBaseService returns a BaseService ready to be embedded and started.
+func NewBaseService() *BaseService {
+	return &BaseService{quit: make(chan struct{})}
+}
+
+// Start marks the service as running. ctx is accepted so embedders have a
+// consistent signature to call through to, even though BaseService itself
+// has nothing to do with it besides check it isn't already done.
+func (b *BaseService) Start(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.started {
+		return ErrAlreadyStarted
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	b.started = true
+	return nil
+}
+
+// Stop closes Quit() and blocks until every goroutine registered via Go has
+// returned, or until ctx is done - whichever happens first. Calling Stop
+// more than once is a no-op.
+func (b *BaseService) Stop(ctx context.Context) error {
+	b.mu.Lock()
+	if b.stopped {
+		b.mu.Unlock()
+		return nil
+	}
+	b.stopped = true
+	close(b.quit)
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Wait blocks until all goroutines registered via Go have returned.
+func (b *BaseService) Wait() {
+	b.wg.Wait()
+}
+
+// Quit returns a channel that is closed once Stop has been called. Long
+// running loops should select on it alongside their normal work.
+func (b *BaseService) Quit() <-chan struct{} {
+	return b.quit
+}
+
+// Stopping reports whether Stop has already been called, without blocking.
+func (b *BaseService) Stopping() bool {
+	select {
+	case <-b.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Go runs fn in a goroutine that Stop/Wait will block on until it returns.
+func (b *BaseService) Go(fn func()) {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		fn()
+	}()
+}
@@ -0,0 +1,45 @@
+package yaad_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"go.uber.org/goleak"
+
+	. "github.com/urjitbhatia/yaad/pkg/yaad"
+)
+
+var _ = Describe("Hub lifecycle", func() {
+	Context("Start/Stop", func() {
+		It("stops its background loops cleanly with no goroutine leaks", func() {
+			defer goleak.VerifyNone(GinkgoT())
+
+			h := NewHub(time.Second, Options{})
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			Expect(h.Start(ctx)).To(Succeed())
+
+			j := NewJobAutoID(time.Now(), []byte("payload"))
+			Expect(h.AddJob(ctx, j)).To(Succeed())
+			Expect(h.Next(ctx)).NotTo(BeNil())
+
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), time.Second)
+			defer stopCancel()
+			Expect(h.Stop(stopCtx)).To(Succeed())
+		})
+
+		It("refuses new jobs once stopping", func() {
+			h := NewHub(time.Second, Options{})
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			Expect(h.Start(ctx)).To(Succeed())
+			Expect(h.Stop(ctx)).To(Succeed())
+
+			j := NewJobAutoID(time.Now(), []byte("payload"))
+			Expect(h.AddJob(ctx, j)).To(MatchError(ErrHubStopping))
+		})
+	})
+})
@@ -0,0 +1,346 @@
+package yaad
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// errShardMiss is returned internally by shard lookups to mean "not owned by
+// this shard" - callers fan out across shards and only surface a miss once
+// every shard has said no.
+var errShardMiss = errors.New("shard: job not owned by this shard")
+
+// shard owns a disjoint slice of a Hub's spokes and reservations behind its
+// own mutex. Hub routes each job to exactly one shard (by job ID when it is
+// added already due, by spoke bound otherwise, and the same shard keeps
+// owning it once reserved), so the shards
+// never need to agree with each other about a given job - this is what lets
+// Hub.Next fan out across shards without a global lock.
+type shard struct {
+	spokeSpan time.Duration
+	spokeMap  map[spokeBound]*Spoke
+	spokes    *PriorityQueue
+
+	pastSpoke    *Spoke
+	currentSpoke *Spoke
+
+	reservedJobs     map[string]*Job
+	reservedDeadline map[string]time.Time
+	ttrQueue         *PriorityQueue
+
+	removedJobsCount uint64
+	mu               sync.Mutex
+}
+
+// newShard returns an empty shard ready to take on jobs.
+func newShard(spokeSpan time.Duration) *shard {
+	s := &shard{
+		spokeSpan:        spokeSpan,
+		spokeMap:         make(map[spokeBound]*Spoke),
+		spokes:           &PriorityQueue{},
+		pastSpoke:        NewSpoke(time.Now().Add(-1*hundredYears), time.Now().Add(hundredYears)),
+		reservedJobs:     make(map[string]*Job),
+		reservedDeadline: make(map[string]time.Time),
+		ttrQueue:         &PriorityQueue{},
+	}
+	heap.Init(s.spokes)
+	heap.Init(s.ttrQueue)
+	return s
+}
+
+// addPastJob adds j straight to this shard's past spoke.
+func (s *shard) addPastJob(j *Job) error {
+	pastLocker := s.pastSpoke.GetLocker()
+	pastLocker.Lock()
+	defer pastLocker.Unlock()
+
+	return s.pastSpoke.AddJob(j)
+}
+
+// addFutureJob adds j, bound to jobBound, to this shard's current spoke or
+// spokeMap, spawning a new spoke if none owns jobBound yet. Returns the
+// outcome attribute Hub.AddJob reports on its span.
+func (s *shard) addFutureJob(j *Job, jobBound spokeBound) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.currentSpoke != nil {
+		currLocker := s.currentSpoke.GetLocker()
+		currLocker.Lock()
+		defer currLocker.Unlock()
+
+		if s.currentSpoke.ContainsJob(j) {
+			if err := s.currentSpoke.AddJob(j); err != nil {
+				return "", err
+			}
+			return "hit_current_spoke", nil
+		}
+	}
+
+	if candidate, ok := s.spokeMap[jobBound]; ok {
+		if err := candidate.AddJob(j); err != nil {
+			return "", err
+		}
+		return "hit_current_spoke", nil
+	}
+
+	sp := NewSpoke(jobBound.start, jobBound.end)
+	if err := sp.AddJob(j); err != nil {
+		return "", err
+	}
+	s.addSpoke(sp)
+	return "spawned_spoke", nil
+}
+
+// addSpoke adds spoke sp to this shard. Caller must hold s.mu.
+func (s *shard) addSpoke(sp *Spoke) {
+	s.spokeMap[sp.spokeBound] = sp
+	heap.Push(s.spokes, sp.AsPriorityItem())
+}
+
+// next returns the next ready job owned by this shard, reserving it, or nil
+// if this shard has nothing ready right now. The returned outcome mirrors
+// Hub.Next's span attribute.
+func (s *shard) next() (*Job, string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pastLocker := s.pastSpoke.GetLocker()
+	pastLocker.Lock()
+	defer pastLocker.Unlock()
+
+	if j := s.pastSpoke.Next(); j != nil {
+		s.reserve(j)
+		return j, "hit_past_spoke"
+	}
+
+	heap.Init(s.spokes)
+
+	if s.currentSpoke != nil {
+		if s.currentSpoke.PendingJobsLen() == 0 && s.currentSpoke.AsTemporalState() == Past {
+			delete(s.spokeMap, s.currentSpoke.spokeBound)
+			s.currentSpoke = nil
+		}
+	}
+
+	if s.currentSpoke == nil {
+		if s.spokes.Len() == 0 {
+			return nil, "miss"
+		}
+
+		item := s.spokes.AtIdx(0)
+		current := item.value.(*Spoke)
+		switch current.AsTemporalState() {
+		case Future:
+			return nil, "miss"
+		case Past, Current:
+			s.currentSpoke = current
+			heap.Pop(s.spokes)
+		}
+	}
+
+	if s.currentSpoke == nil {
+		logrus.Panic("Unreachable state :: shard has a nil spoke after candidate search")
+	}
+
+	currentLocker := s.currentSpoke.GetLocker()
+	currentLocker.Lock()
+	defer currentLocker.Unlock()
+
+	j := s.currentSpoke.Next()
+	if j == nil {
+		return nil, "miss"
+	}
+
+	s.reserve(j)
+	return j, "hit_current_spoke"
+}
+
+// reserve records j as reserved and schedules its TTR deadline. Caller must
+// hold s.mu.
+func (s *shard) reserve(j *Job) {
+	s.reservedJobs[j.id] = j
+	deadline := time.Now().Add(j.ttr)
+	s.reservedDeadline[j.id] = deadline
+	heap.Push(s.ttrQueue, &Item{value: j, priority: deadline})
+}
+
+// cancelJob removes jobID from this shard, whether reserved or still
+// pending, and reports the outcome attribute Hub.CancelJob's span uses.
+// Returns errShardMiss if this shard doesn't own jobID.
+func (s *shard) cancelJob(jobID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.reservedJobs[jobID]; ok {
+		delete(s.reservedJobs, jobID)
+		delete(s.reservedDeadline, jobID)
+		s.removedJobsCount++
+		return "hit_reserved", nil
+	}
+
+	sp, err := s.findOwnerSpokeLocked(jobID)
+	if err != nil {
+		return "", errShardMiss
+	}
+	sp.CancelJob(jobID)
+	s.removedJobsCount++
+	return "hit_spoke", nil
+}
+
+// findOwnerSpoke returns the spoke owning jobID within this shard.
+func (s *shard) findOwnerSpoke(jobID string) (*Spoke, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.findOwnerSpokeLocked(jobID)
+}
+
+// findOwnerSpokeLocked is findOwnerSpoke without locking. Caller must hold s.mu.
+func (s *shard) findOwnerSpokeLocked(jobID string) (*Spoke, error) {
+	if s.pastSpoke.OwnsJob(jobID) {
+		return s.pastSpoke, nil
+	}
+	if s.currentSpoke != nil && s.currentSpoke.OwnsJob(jobID) {
+		return s.currentSpoke, nil
+	}
+	for _, v := range s.spokeMap {
+		if v.OwnsJob(jobID) {
+			return v, nil
+		}
+	}
+	return nil, errors.New("Cannot find job owner spoke")
+}
+
+// touchJob extends jobID's TTR deadline if this shard has it reserved.
+func (s *shard) touchJob(jobID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.reservedJobs[jobID]
+	if !ok {
+		return false
+	}
+	deadline := time.Now().Add(j.ttr)
+	s.reservedDeadline[jobID] = deadline
+	heap.Push(s.ttrQueue, &Item{value: j, priority: deadline})
+	return true
+}
+
+// releaseJob drops jobID's reservation bookkeeping and hands the job back so
+// Hub can re-AddJob it with its new priority/delay.
+func (s *shard) releaseJob(jobID string) (*Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.reservedJobs[jobID]
+	if !ok {
+		return nil, false
+	}
+	delete(s.reservedJobs, jobID)
+	delete(s.reservedDeadline, jobID)
+	return j, true
+}
+
+// restoreReservation re-adds j to this shard's reservation bookkeeping with
+// a fresh TTR deadline. Hub calls this to undo releaseJob/popExpiredReservations
+// when the subsequent re-AddJob fails, so the job stays reserved instead of
+// being silently dropped.
+func (s *shard) restoreReservation(j *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reserve(j)
+}
+
+// popExpiredReservations pops every ttrQueue entry whose deadline is <= now
+// and returns the underlying jobs. A ttrQueue entry is stale (and skipped)
+// if the job was since deleted/released, or touched again after this entry
+// was queued - reservedDeadline always tracks the live deadline, so a
+// mismatch means a newer entry will fire later.
+func (s *shard) popExpiredReservations(now time.Time) []*Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var expired []*Job
+	for s.ttrQueue.Len() > 0 {
+		item := s.ttrQueue.AtIdx(0)
+		if item.priority.After(now) {
+			break
+		}
+		heap.Pop(s.ttrQueue)
+
+		j := item.value.(*Job)
+		if deadline, ok := s.reservedDeadline[j.id]; !ok || !deadline.Equal(item.priority) {
+			continue
+		}
+		delete(s.reservedJobs, j.id)
+		delete(s.reservedDeadline, j.id)
+		expired = append(expired, j)
+	}
+	return expired
+}
+
+// pendingJobsCount returns how many jobs are pending (not yet reserved) in
+// this shard.
+func (s *shard) pendingJobsCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pendingJobsCountLocked()
+}
+
+func (s *shard) pendingJobsCountLocked() int {
+	count := s.pastSpoke.PendingJobsLen()
+	for _, v := range s.spokeMap {
+		count += v.PendingJobsLen()
+	}
+	return count
+}
+
+// prune clears spokes in this shard that are expired and have no jobs,
+// returning the number of spokes examined.
+func (s *shard) prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for k, v := range s.spokeMap {
+		if v.IsExpired() && v.PendingJobsLen() == 0 {
+			delete(s.spokeMap, k)
+		}
+		pruned++
+	}
+	return pruned
+}
+
+// shardStatus is a point-in-time snapshot of a shard, used by Hub.Status.
+type shardStatus struct {
+	spokes, pending, reserved int
+	removed                   uint64
+}
+
+func (s *shard) status() shardStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return shardStatus{
+		spokes:   len(s.spokeMap),
+		pending:  s.pendingJobsCountLocked(),
+		reserved: len(s.reservedJobs),
+		removed:  s.removedJobsCount,
+	}
+}
+
+// logStatus logs this shard's spokes at the given index, mirroring the
+// per-spoke detail the single-shard Hub used to log directly.
+func (s *shard) logStatus(i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	logrus.Infof("Shard %d: past spoke has %d jobs", i, s.pastSpoke.PendingJobsLen())
+	for _, sp := range s.spokeMap {
+		logrus.Infof("Shard %d: spoke %s has %d jobs", i, sp.id, sp.PendingJobsLen())
+		logrus.Debugf("Shard %d: spoke %s start: %s end %s", i, sp.id, sp.start.String(), sp.end.String())
+	}
+}
@@ -0,0 +1,113 @@
+package yaad
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Options carries the optional tracing/metrics providers threaded through
+// Hub, Server and TubeYaad. The zero value is safe to use: a nil
+// TracerProvider falls back to the global otel provider (a noop until the
+// caller installs a real one), a nil Metrics just means Hub won't record
+// the extra histograms/gauges on top of its usual logrus Status output, and
+// Shards <= 0 falls back to runtime.GOMAXPROCS(0).
+type Options struct {
+	TracerProvider trace.TracerProvider
+	Metrics        *Metrics
+	// Shards is the number of independent shards Hub partitions its spokes
+	// and reservations across. <= 0 means runtime.GOMAXPROCS(0).
+	Shards int
+}
+
+// Tracer returns the tracer to use for spans, falling back to the global
+// otel TracerProvider when none was configured.
+func (o Options) Tracer() trace.Tracer {
+	tp := o.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer("github.com/urjitbhatia/yaad/pkg/yaad")
+}
+
+// Metrics bundles the OpenTelemetry instruments that Hub records onto.
+// Construct one with NewMetrics against a Meter from whatever
+// MeterProvider the caller wired up (a Prometheus-backed one included), and
+// pass it in via Options; Server and TubeYaad hand it down to the Hub that
+// records onto it.
+type Metrics struct {
+	// JobLatency is the delta between a job's scheduled TriggerAt and the
+	// moment it was actually handed out by Next.
+	JobLatency metric.Float64Histogram
+	// SpokeCount samples the total number of spokes across the Hub's shards
+	// alongside every Status call.
+	SpokeCount metric.Int64Histogram
+	// TTRExpired counts reservations that were auto-released because their
+	// TTR deadline passed before the consumer deleted/released/touched them.
+	TTRExpired metric.Int64Counter
+
+	pendingJobs  int64
+	reservedJobs int64
+}
+
+// NewMetrics creates the instruments yaad records onto meter.
+func NewMetrics(meter metric.Meter) (*Metrics, error) {
+	m := &Metrics{}
+
+	var err error
+	if m.JobLatency, err = meter.Float64Histogram(
+		"yaad.job.latency",
+		metric.WithDescription("seconds between a job's scheduled trigger time and when it was reserved"),
+		metric.WithUnit("s"),
+	); err != nil {
+		return nil, err
+	}
+
+	if m.SpokeCount, err = meter.Int64Histogram(
+		"yaad.hub.spoke_count",
+		metric.WithDescription("number of spokes in the hub, sampled alongside Status"),
+	); err != nil {
+		return nil, err
+	}
+
+	if m.TTRExpired, err = meter.Int64Counter(
+		"yaad.job.ttr_expired",
+		metric.WithDescription("reservations auto-released because their TTR deadline expired"),
+	); err != nil {
+		return nil, err
+	}
+
+	if _, err = meter.Int64ObservableGauge(
+		"yaad.hub.pending_jobs",
+		metric.WithDescription("jobs currently pending in the hub"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(atomic.LoadInt64(&m.pendingJobs))
+			return nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+
+	if _, err = meter.Int64ObservableGauge(
+		"yaad.hub.reserved_jobs",
+		metric.WithDescription("jobs currently reserved and awaiting delete/release/touch"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(atomic.LoadInt64(&m.reservedJobs))
+			return nil
+		}),
+	); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// observeCounts updates the gauges backing pending/reserved job counts.
+// Hub calls this from the same ticker that drives Status.
+func (m *Metrics) observeCounts(pending, reserved int) {
+	atomic.StoreInt64(&m.pendingJobs, int64(pending))
+	atomic.StoreInt64(&m.reservedJobs, int64(reserved))
+}
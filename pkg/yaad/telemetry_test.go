@@ -0,0 +1,19 @@
+package yaad_test
+
+import (
+	"go.opentelemetry.io/otel/metric/noop"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/urjitbhatia/yaad/pkg/yaad"
+)
+
+var _ = Describe("Metrics", func() {
+	It("builds its instruments against a noop meter without error", func() {
+		meter := noop.NewMeterProvider().Meter("yaad_test")
+		m, err := NewMetrics(meter)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m).NotTo(BeNil())
+	})
+})
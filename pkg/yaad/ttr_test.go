@@ -0,0 +1,51 @@
+package yaad_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/urjitbhatia/yaad/pkg/yaad"
+)
+
+var _ = Describe("TTR expiry", func() {
+	It("re-queues a reservation once its TTR deadline passes", func() {
+		h := NewHub(50*time.Millisecond, Options{})
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		Expect(h.Start(ctx)).To(Succeed())
+		defer h.Stop(ctx)
+
+		j := NewJobAutoID(time.Now(), []byte("payload"))
+		j.SetOpts(1, 10*time.Millisecond)
+		Expect(h.AddJob(ctx, j)).To(Succeed())
+
+		Expect(h.Next(ctx)).NotTo(BeNil())
+		Expect(h.Next(ctx)).To(BeNil(), "job should still be reserved")
+
+		Eventually(func() *Job { return h.Next(ctx) }, time.Second, 10*time.Millisecond).ShouldNot(BeNil())
+	})
+
+	It("extends the deadline on touch", func() {
+		h := NewHub(50*time.Millisecond, Options{})
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		Expect(h.Start(ctx)).To(Succeed())
+		defer h.Stop(ctx)
+
+		j := NewJobAutoID(time.Now(), []byte("payload"))
+		j.SetOpts(1, 200*time.Millisecond)
+		Expect(h.AddJob(ctx, j)).To(Succeed())
+
+		got := h.Next(ctx)
+		Expect(got).NotTo(BeNil())
+		// Touch late in the original TTR so the window below runs past the
+		// original deadline and would see the job re-queued without the touch.
+		time.Sleep(150 * time.Millisecond)
+		Expect(h.TouchJob(got.ID())).To(Succeed())
+
+		Consistently(func() *Job { return h.Next(ctx) }, 150*time.Millisecond, 10*time.Millisecond).Should(BeNil())
+	})
+})